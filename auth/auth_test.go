@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerClientHandshake(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	a := NewAuthenticator("correct-key", time.Minute)
+
+	clientErr := make(chan error, 1)
+	go func() {
+		clientErr <- Client(clientConn, bufio.NewReader(clientConn), "correct-key", "peer-1")
+	}()
+
+	id, err := a.Server(serverConn, bufio.NewReader(serverConn))
+	if err != nil {
+		t.Fatalf("Server handshake failed: %v", err)
+	}
+	if id != "peer-1" {
+		t.Fatalf("got client ID %q, want %q", id, "peer-1")
+	}
+	if err := <-clientErr; err != nil {
+		t.Fatalf("Client handshake failed: %v", err)
+	}
+}
+
+func TestServerRejectsWrongKey(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	a := NewAuthenticator("correct-key", time.Minute)
+
+	go func() {
+		Client(clientConn, bufio.NewReader(clientConn), "wrong-key", "peer-1")
+	}()
+
+	if _, err := a.Server(serverConn, bufio.NewReader(serverConn)); err == nil {
+		t.Fatal("expected error for wrong key, got nil")
+	}
+}
+
+func TestServerRejectsMalformedResponse(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	a := NewAuthenticator("correct-key", time.Minute)
+
+	go func() {
+		reader := bufio.NewReader(clientConn)
+		reader.ReadString('\n')
+		clientConn.Write([]byte("not-enough-fields\n"))
+	}()
+
+	_, err := a.Server(serverConn, bufio.NewReader(serverConn))
+	if err == nil {
+		t.Fatal("expected error for malformed response, got nil")
+	}
+}
+
+// TestServerRejectsReplayedResponse simulates an attacker replaying a
+// response captured from an earlier, unrelated handshake against a fresh
+// challenge. Since the server issues a new random nonce every time and the
+// MAC is bound to it, the replayed response's MAC won't match and the
+// handshake must fail.
+func TestServerRejectsReplayedResponse(t *testing.T) {
+	staleTimestamp := time.Now().Unix()
+	staleMAC := computeMAC("correct-key", "captured-nonce", staleTimestamp, "peer-1")
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	a := NewAuthenticator("correct-key", time.Minute)
+
+	go func() {
+		reader := bufio.NewReader(clientConn)
+		reader.ReadString('\n') // discard the server's fresh challenge
+		fmt.Fprintf(clientConn, "%s %s %d\n", staleMAC, "peer-1", staleTimestamp)
+	}()
+
+	if _, err := a.Server(serverConn, bufio.NewReader(serverConn)); err == nil {
+		t.Fatal("expected error replaying a response bound to a different nonce, got nil")
+	}
+}
+
+func TestServerRejectsStaleTimestamp(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	a := NewAuthenticator("correct-key", time.Second)
+
+	go func() {
+		reader := bufio.NewReader(clientConn)
+		line, _ := reader.ReadString('\n')
+		fields := strings.Fields(line)
+		staleTimestamp := time.Now().Add(-time.Hour).Unix()
+		mac := computeMAC("correct-key", fields[1], staleTimestamp, "peer-1")
+		fmt.Fprintf(clientConn, "%s %s %d\n", mac, "peer-1", staleTimestamp)
+	}()
+
+	if _, err := a.Server(serverConn, bufio.NewReader(serverConn)); err == nil {
+		t.Fatal("expected error for stale timestamp outside allowed skew, got nil")
+	}
+}
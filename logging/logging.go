@@ -0,0 +1,212 @@
+// Package logging wraps the standard library's log.Logger with leveled,
+// categorized output so that routine chatter ("heartbeat received") can be
+// silenced independently of real errors, and so log lines can optionally
+// be emitted as JSON for shipping into Loki/ELK. An optional syslog sink
+// (see OpenSyslog) delivers the same lines into the system journal.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fields carries structured key/value pairs onto a single log line, e.g.
+// peer_id, attempt, elapsed_ms, script_name, exit_code.
+type Fields map[string]interface{}
+
+// Logger writes leveled, optionally-categorized log lines to out, in
+// either plain text or JSON. Debug lines are only emitted for categories
+// enabled via NewFromEnv's WATCHDOG_TRACE spec.
+type Logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format string
+	syslog SyslogWriter
+
+	traceAll   bool
+	categories map[string]bool
+}
+
+// New creates a Logger writing to out. format is "text" or "json"; any
+// other value falls back to "text". trace is the WATCHDOG_TRACE spec, e.g.
+// "net,auth,scripts" or "all". syslog is optional (nil disables it); when
+// set, every log call is additionally delivered to it at the matching
+// syslog severity.
+func New(out io.Writer, format string, trace string, syslog SyslogWriter) *Logger {
+	if format != "json" {
+		format = "text"
+	}
+	categories, all := parseTrace(trace)
+	return &Logger{out: out, format: format, syslog: syslog, traceAll: all, categories: categories}
+}
+
+// NewFromEnv is a convenience constructor that reads the trace spec from
+// the WATCHDOG_TRACE environment variable.
+func NewFromEnv(out io.Writer, format string, syslog SyslogWriter) *Logger {
+	return New(out, format, os.Getenv("WATCHDOG_TRACE"), syslog)
+}
+
+func parseTrace(spec string) (map[string]bool, bool) {
+	categories := make(map[string]bool)
+	all := false
+	for _, token := range strings.Split(spec, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		if token == "all" {
+			all = true
+			continue
+		}
+		categories[token] = true
+	}
+	return categories, all
+}
+
+// traceEnabled reports whether debug logging for category is turned on.
+func (l *Logger) traceEnabled(category string) bool {
+	if l.traceAll {
+		return true
+	}
+	return l.categories[category]
+}
+
+// Debugf logs a debug-level message in category. It is silently dropped
+// unless category (or "all") is listed in WATCHDOG_TRACE.
+func (l *Logger) Debugf(category string, format string, args ...interface{}) {
+	if !l.traceEnabled(category) {
+		return
+	}
+	l.write("debug", category, fmt.Sprintf(format, args...), nil)
+}
+
+// Infof logs a routine, always-on informational message.
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.write("info", "", fmt.Sprintf(format, args...), nil)
+}
+
+// Noticef logs an always-on message one notch more significant than Infof,
+// e.g. a recovery script that ran successfully.
+func (l *Logger) Noticef(format string, args ...interface{}) {
+	l.write("notice", "", fmt.Sprintf(format, args...), nil)
+}
+
+// Warnf logs a recoverable problem worth an operator's attention.
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.write("warn", "", fmt.Sprintf(format, args...), nil)
+}
+
+// Errorf logs a failure.
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.write("error", "", fmt.Sprintf(format, args...), nil)
+}
+
+// WithFields returns an Entry that attaches fields (peer_id, attempt,
+// exit_code, ...) to every line it logs.
+func (l *Logger) WithFields(fields Fields) *Entry {
+	return &Entry{logger: l, fields: fields}
+}
+
+// Entry is a Logger bound to a fixed set of structured fields.
+type Entry struct {
+	logger *Logger
+	fields Fields
+}
+
+func (e *Entry) Debugf(category string, format string, args ...interface{}) {
+	if !e.logger.traceEnabled(category) {
+		return
+	}
+	e.logger.write("debug", category, fmt.Sprintf(format, args...), e.fields)
+}
+
+func (e *Entry) Infof(format string, args ...interface{}) {
+	e.logger.write("info", "", fmt.Sprintf(format, args...), e.fields)
+}
+
+func (e *Entry) Noticef(format string, args ...interface{}) {
+	e.logger.write("notice", "", fmt.Sprintf(format, args...), e.fields)
+}
+
+func (e *Entry) Warnf(format string, args ...interface{}) {
+	e.logger.write("warn", "", fmt.Sprintf(format, args...), e.fields)
+}
+
+func (e *Entry) Errorf(format string, args ...interface{}) {
+	e.logger.write("error", "", fmt.Sprintf(format, args...), e.fields)
+}
+
+func (l *Logger) write(level string, category string, msg string, fields Fields) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now().Format(time.RFC3339)
+
+	if l.format == "json" {
+		entry := make(map[string]interface{}, len(fields)+4)
+		for k, v := range fields {
+			entry[k] = v
+		}
+		entry["time"] = now
+		entry["level"] = level
+		if category != "" {
+			entry["category"] = category
+		}
+		entry["msg"] = msg
+		data, err := json.Marshal(entry)
+		if err != nil {
+			fmt.Fprintln(l.out, now, level, msg)
+		} else {
+			fmt.Fprintln(l.out, string(data))
+		}
+	} else {
+		line := fmt.Sprintf("%s [%s]", now, level)
+		if category != "" {
+			line += fmt.Sprintf("[%s]", category)
+		}
+		line += " " + msg
+		for k, v := range fields {
+			line += fmt.Sprintf(" %s=%v", k, v)
+		}
+		fmt.Fprintln(l.out, line)
+	}
+
+	l.writeSyslog(level, category, msg, fields)
+}
+
+// writeSyslog delivers the log line to the syslog sink, if one is
+// configured, at the syslog severity matching level.
+func (l *Logger) writeSyslog(level string, category string, msg string, fields Fields) {
+	if l.syslog == nil {
+		return
+	}
+	line := msg
+	if category != "" {
+		line = fmt.Sprintf("[%s] %s", category, line)
+	}
+	for k, v := range fields {
+		line += fmt.Sprintf(" %s=%v", k, v)
+	}
+
+	var err error
+	switch level {
+	case "debug":
+		err = l.syslog.Debug(line)
+	case "notice":
+		err = l.syslog.Notice(line)
+	case "warn":
+		err = l.syslog.Warning(line)
+	case "error":
+		err = l.syslog.Err(line)
+	default:
+		err = l.syslog.Info(line)
+	}
+	if err != nil {
+		fmt.Fprintln(l.out, "Error writing to syslog:", err)
+	}
+}
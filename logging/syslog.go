@@ -0,0 +1,38 @@
+package logging
+
+import "strings"
+
+// SyslogWriter is the subset of *log/syslog.Writer's API the logger needs.
+// Platform-specific files provide OpenSyslog to build one; log/syslog isn't
+// available on Windows, so that build provides a stub that always errors.
+type SyslogWriter interface {
+	Debug(m string) error
+	Info(m string) error
+	Notice(m string) error
+	Warning(m string) error
+	Err(m string) error
+}
+
+// ParseSyslogSpec parses a "syslog://facility.level/tag" destination spec,
+// as used in the `logs` config value. The level segment is accepted for
+// compatibility but otherwise ignored: every log call already carries its
+// own severity, so there's nothing left for a static minimum level to do.
+func ParseSyslogSpec(spec string) (facility string, tag string, ok bool) {
+	const prefix = "syslog://"
+	if !strings.HasPrefix(spec, prefix) {
+		return "", "", false
+	}
+	rest := strings.TrimPrefix(spec, prefix)
+	authority, tag, _ := strings.Cut(rest, "/")
+	facility = authority
+	if dot := strings.IndexByte(authority, '.'); dot >= 0 {
+		facility = authority[:dot]
+	}
+	if facility == "" {
+		facility = "daemon"
+	}
+	if tag == "" {
+		tag = "watchdog"
+	}
+	return facility, tag, true
+}
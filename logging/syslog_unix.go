@@ -0,0 +1,54 @@
+//go:build !windows
+
+package logging
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+)
+
+// OpenSyslog opens a syslog writer under the given facility (default
+// "daemon") and tag.
+func OpenSyslog(facility string, tag string) (SyslogWriter, error) {
+	priority, err := syslogFacility(facility)
+	if err != nil {
+		return nil, err
+	}
+	w, err := syslog.New(priority|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("opening syslog: %w", err)
+	}
+	return w, nil
+}
+
+func syslogFacility(name string) (syslog.Priority, error) {
+	switch strings.ToLower(name) {
+	case "", "daemon":
+		return syslog.LOG_DAEMON, nil
+	case "user":
+		return syslog.LOG_USER, nil
+	case "mail":
+		return syslog.LOG_MAIL, nil
+	case "syslog":
+		return syslog.LOG_SYSLOG, nil
+	case "local0":
+		return syslog.LOG_LOCAL0, nil
+	case "local1":
+		return syslog.LOG_LOCAL1, nil
+	case "local2":
+		return syslog.LOG_LOCAL2, nil
+	case "local3":
+		return syslog.LOG_LOCAL3, nil
+	case "local4":
+		return syslog.LOG_LOCAL4, nil
+	case "local5":
+		return syslog.LOG_LOCAL5, nil
+	case "local6":
+		return syslog.LOG_LOCAL6, nil
+	case "local7":
+		return syslog.LOG_LOCAL7, nil
+	default:
+		return 0, fmt.Errorf("unknown syslog facility %q", name)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package logging
+
+import "fmt"
+
+// OpenSyslog always fails on Windows: log/syslog isn't available there.
+// The --syslog flag still parses; it just can't be satisfied.
+func OpenSyslog(facility string, tag string) (SyslogWriter, error) {
+	return nil, fmt.Errorf("syslog is not supported on this platform")
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Peer tracks the liveness state watchdog needs for a single remote
+// watchdog instance: when it last checked in, how many consecutive
+// heartbeats it has missed, and the timeout that applies to it.
+type Peer struct {
+	ID            string
+	LastHeartbeat time.Time
+	Attempts      int
+	Timeout       time.Duration
+	registeredAt  time.Time
+	tierHold      int
+}
+
+// PeerTimeout describes a peer that has missed its heartbeat deadline,
+// along with the escalation tier the server should act on.
+type PeerTimeout struct {
+	ID            string
+	Attempts      int
+	Tier          int
+	LastHeartbeat time.Time
+}
+
+// PeerRegistry tracks per-peer heartbeat state so a single server can watch
+// many clients, each with its own timeout and escalation tier, instead of
+// a single shared lastHeartbeat/attempts pair.
+type PeerRegistry struct {
+	mu             sync.Mutex
+	peers          map[string]*Peer
+	defaultTimeout time.Duration
+}
+
+// NewPeerRegistry creates a registry that falls back to defaultTimeout for
+// any peer that doesn't declare its own.
+func NewPeerRegistry(defaultTimeout time.Duration) *PeerRegistry {
+	return &PeerRegistry{
+		peers:          make(map[string]*Peer),
+		defaultTimeout: defaultTimeout,
+	}
+}
+
+// Expect pre-registers a peer that is expected to check in, so the server
+// can alert if it never does rather than only noticing when a previously
+// seen peer goes quiet. A zero timeout means "use the registry default".
+func (r *PeerRegistry) Expect(id string, timeout time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.peers[id]; exists {
+		return
+	}
+	r.peers[id] = &Peer{ID: id, Timeout: timeout, registeredAt: time.Now()}
+}
+
+// Touch records a successful heartbeat from id, resetting its attempt
+// counter and creating an entry for it if this is the first time it's
+// been seen.
+func (r *PeerRegistry) Touch(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	peer, exists := r.peers[id]
+	if !exists {
+		peer = &Peer{ID: id, registeredAt: time.Now()}
+		r.peers[id] = peer
+	}
+	peer.LastHeartbeat = time.Now()
+	peer.Attempts = 0
+}
+
+// CheckTimeouts advances the attempt counter for every peer that has gone
+// quiet past its timeout and returns one PeerTimeout per peer currently in
+// a failed state, tier capped at maxTier so the escalation stops at the
+// highest configured script tier instead of climbing forever.
+func (r *PeerRegistry) CheckTimeouts(now time.Time, maxTier int) []PeerTimeout {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var timedOut []PeerTimeout
+	for _, peer := range r.peers {
+		timeout := peer.Timeout
+		if timeout == 0 {
+			timeout = r.defaultTimeout
+		}
+
+		since := peer.LastHeartbeat
+		if since.IsZero() {
+			since = peer.registeredAt
+		}
+
+		if now.Sub(since) > timeout {
+			peer.Attempts++
+			tier := peer.Attempts
+			if tier > maxTier {
+				tier = maxTier
+			}
+			if peer.tierHold > 0 && peer.tierHold < tier {
+				tier = peer.tierHold
+			}
+			timedOut = append(timedOut, PeerTimeout{ID: peer.ID, Attempts: peer.Attempts, Tier: tier, LastHeartbeat: since})
+		} else {
+			peer.Attempts = 0
+			peer.tierHold = 0
+		}
+	}
+	return timedOut
+}
+
+// HoldTier pins id's escalation tier at tier until ResetAttempts or
+// ClearTierHold is called, so a script that exits EX_TEMPFAIL is re-run at
+// the same tier next tick instead of escalating.
+func (r *PeerRegistry) HoldTier(id string, tier int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if peer, exists := r.peers[id]; exists {
+		peer.tierHold = tier
+	}
+}
+
+// ClearTierHold releases any tier pinned by HoldTier, letting id's tier
+// resume climbing with its attempt count.
+func (r *PeerRegistry) ClearTierHold(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if peer, exists := r.peers[id]; exists {
+		peer.tierHold = 0
+	}
+}
+
+// ResetAttempts clears id's attempt count and tier hold, as if it had just
+// sent a successful heartbeat, without touching LastHeartbeat itself. Used
+// when a recovery script reports that it resolved the underlying problem.
+func (r *PeerRegistry) ResetAttempts(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if peer, exists := r.peers[id]; exists {
+		peer.Attempts = 0
+		peer.tierHold = 0
+	}
+}
+
+// parseExpectedPeers parses the --expected-peers / config "expected-peers"
+// value: a comma-separated list of peer IDs, each optionally suffixed with
+// ":<timeout-seconds>" to override the server's default timeout, e.g.
+// "db1:120,db2,cache1:300".
+func parseExpectedPeers(raw string, defaultTimeout time.Duration) (map[string]time.Duration, error) {
+	peers := make(map[string]time.Duration)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, timeoutStr, hasTimeout := strings.Cut(entry, ":")
+		id = strings.TrimSpace(id)
+		if id == "" {
+			return nil, fmt.Errorf("expected-peers entry has no peer id: %q", entry)
+		}
+		timeout := defaultTimeout
+		if hasTimeout {
+			seconds, err := strconv.Atoi(strings.TrimSpace(timeoutStr))
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeout for peer %q: %w", id, err)
+			}
+			timeout = time.Duration(seconds) * time.Second
+		}
+		peers[id] = timeout
+	}
+	return peers, nil
+}
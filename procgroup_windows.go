@@ -0,0 +1,18 @@
+//go:build windows
+
+package main
+
+import "os/exec"
+
+// setProcessGroup is a no-op on Windows; exec.Cmd.Cancel falls back to
+// killing just the script process itself.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the script process directly, since Windows has
+// no POSIX process-group semantics to kill by.
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
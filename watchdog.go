@@ -2,20 +2,39 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
+
+	"github.com/thoughtwave/watchdog/auth"
+	"github.com/thoughtwave/watchdog/logging"
 )
 
+// exitTempFail is the sysexits.h EX_TEMPFAIL code. A recovery script
+// exiting with this code is asking to be re-run next tick rather than
+// having its failure escalate to the next tier.
+const exitTempFail = 75
+
+// handshakeTimeout bounds how long handleConnection will wait on the
+// client during authentication, so one client that connects and never
+// sends its response line can't tie up a goroutine (or, before
+// handleConnection was dispatched per-connection, the whole accept loop)
+// forever.
+const handshakeTimeout = 10 * time.Second
+
 const (
 	DEFAULT_PORT        = "4848"
 	DEFAULT_TIMEOUT     = 600 // 10 minutes in seconds
@@ -24,6 +43,8 @@ const (
 	DEFAULT_CONFIG_FILE = "/etc/watchdog.conf"
 )
 
+const usage = "Usage: watchdog --key <key> --server | --client --remote <remote-host> [--port <port>] [--timeout <seconds>] [--dir <directory>] [--logs <logfile>] [--foreground] [--attempts <number>] [--tls --cert <certfile> --key-file <keyfile> [--ca <cafile>] [--mtls]] [--legacy-auth] [--max-skew <seconds>] [--client-id <id>] [--expected-peers <id[:timeout],...>] [--log-format text|json] [--syslog [--syslog-facility <facility>] [--syslog-tag <tag>]] [--script-timeout <seconds>] [--script-concurrency <number>] [--config <config-file>]"
+
 func readConfig(configFile string) (map[string]string, error) {
 	config := make(map[string]string)
 	file, err := os.Open(configFile)
@@ -44,129 +65,414 @@ func readConfig(configFile string) (map[string]string, error) {
 	return config, scanner.Err()
 }
 
-func handleConnection(conn net.Conn, key string, logger *log.Logger, lastHeartbeat *time.Time, mu *sync.Mutex) bool {
+// buildServerTLSConfig loads the server's certificate/key pair and, when mtls
+// is requested, the CA bundle used to verify client certificates.
+func buildServerTLSConfig(certFile, keyFile, caFile string, mtls bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if mtls {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// buildClientTLSConfig configures the client side of the connection. A CA
+// file lets the client verify the server's certificate against a private CA;
+// mtls additionally presents a client certificate for the server to verify.
+func buildClientTLSConfig(certFile, keyFile, caFile string, mtls bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if mtls {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client TLS certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	caCert, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("failed to parse CA certificate %s", caFile)
+	}
+	return pool, nil
+}
+
+// logDialError classifies connection errors so operators can tell a plain
+// network hiccup apart from a TLS misconfiguration in the logs.
+func logDialError(logger *logging.Logger, err error) {
+	var recordErr tls.RecordHeaderError
+	var certErr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &recordErr):
+		logger.Errorf("TLS record header error while connecting (is the server expecting TLS?): %v", err)
+	case errors.As(err, &certErr):
+		logger.Errorf("TLS certificate validation error while connecting: %v", err)
+	default:
+		logger.Errorf("Error connecting to server: %v", err)
+	}
+}
+
+// handleConnection authenticates conn and returns the peer ID the
+// heartbeat should be recorded under, falling back to the remote address
+// when the protocol in use doesn't carry a client ID.
+func handleConnection(conn net.Conn, key string, legacyAuth bool, authenticator *auth.Authenticator, logger *logging.Logger) (string, bool) {
 	defer conn.Close()
 	reader := bufio.NewReader(conn)
-	line, err := reader.ReadString('\n')
-	if err != nil {
-		logger.Println("Error reading from connection:", err)
-		return false
-	}
-	message := strings.TrimSpace(line)
-	if message == key {
-		logger.Println("Heartbeat received")
-		conn.Write([]byte("OK\n"))
-		mu.Lock()
-		*lastHeartbeat = time.Now()
-		mu.Unlock()
-		return true
+
+	if err := conn.SetReadDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		logger.Warnf("Error setting handshake read deadline: %v", err)
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	clientID := ""
+	if legacyAuth {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			logger.Errorf("Error reading from connection: %v", err)
+			return "", false
+		}
+		if strings.TrimSpace(line) != key {
+			logger.Warnf("Invalid key received")
+			conn.Write([]byte("ERROR\n"))
+			return "", false
+		}
 	} else {
-		logger.Println("Invalid key received")
-		conn.Write([]byte("ERROR\n"))
-		return false
+		id, err := authenticator.Server(conn, reader)
+		if err != nil {
+			logger.Warnf("Authentication failed: %v", err)
+			conn.Write([]byte("ERROR\n"))
+			return "", false
+		}
+		clientID = id
+		logger.Debugf("auth", "Challenge-response succeeded for %s", clientID)
+	}
+
+	if clientID == "" {
+		clientID = conn.RemoteAddr().String()
+	}
+
+	// Always-on rather than Debugf("net", ...): the syslog sink maps this
+	// message to LOG_INFO, and a message gated behind WATCHDOG_TRACE would
+	// never reach the syslog writer at all since Debugf drops it before
+	// write() (and thus writeSyslog) ever runs.
+	logger.Infof("Heartbeat received from %s", clientID)
+	conn.Write([]byte("OK\n"))
+	return clientID, true
+}
+
+// ScriptResult reports how one recovery script finished. ExitCode is -1 if
+// the script never produced one (start failure or timeout).
+type ScriptResult struct {
+	Name     string
+	ExitCode int
+}
+
+// tierOutcomeSuccess is the aggregate outcome for a tier where every script
+// exited 0.
+const tierOutcomeSuccess = 0
+
+// aggregateTierOutcome reduces a tier's script results to a single,
+// deterministic outcome, since results are appended by concurrent
+// goroutines (see runScripts) in an order callers can't rely on. The most
+// significant result wins: any script exiting with an unrecognized
+// non-zero code means something actually failed and the peer should
+// escalate, exitTempFail ("re-run this tier next tick") is the next
+// strongest signal, and tierOutcomeSuccess only applies when every script
+// in the tier exited 0.
+func aggregateTierOutcome(results []ScriptResult) int {
+	sawTempFail := false
+	for _, result := range results {
+		switch result.ExitCode {
+		case tierOutcomeSuccess:
+		case exitTempFail:
+			sawTempFail = true
+		default:
+			return result.ExitCode
+		}
+	}
+	if sawTempFail {
+		return exitTempFail
 	}
+	return tierOutcomeSuccess
 }
 
-func runScripts(scriptDir string, logger *log.Logger) {
+// runScripts executes the recovery scripts for the given escalation tier
+// (every file in scriptDir prefixed "NN-", zero-padded to two digits, e.g.
+// tier 1 runs "01-notify") concurrently, bounded by concurrency in-flight
+// at a time, each capped at timeout. peerID/lastHeartbeat/attempts/tier are
+// injected as WATCHDOG_* environment variables so scripts can make
+// informed recovery decisions.
+func runScripts(scriptDir string, tier int, peerID string, lastHeartbeat time.Time, attempts int, timeout time.Duration, concurrency int, logger *logging.Logger) []ScriptResult {
 	files, err := ioutil.ReadDir(scriptDir)
 	if err != nil {
-		logger.Println("Error reading script directory:", err)
-		return
+		logger.Errorf("Error reading script directory: %v", err)
+		return nil
 	}
 
+	prefix := fmt.Sprintf("%02d-", tier)
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var results []ScriptResult
+
 	for _, file := range files {
-		if strings.HasPrefix(file.Name(), "00") {
-			cmd := exec.Command(scriptDir + file.Name())
-			err := cmd.Run()
-			if err != nil {
-				logger.Println("Error running script:", file.Name(), err)
-			} else {
-				logger.Println("Successfully ran script:", file.Name())
-			}
+		if !strings.HasPrefix(file.Name(), prefix) {
+			continue
 		}
+		name := file.Name()
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			result := runScript(scriptDir, name, peerID, lastHeartbeat, attempts, tier, timeout, logger)
+			mu.Lock()
+			results = append(results, result)
+			mu.Unlock()
+		}()
 	}
+	wg.Wait()
+	return results
 }
 
-func startServer(port string, timeout time.Duration, scriptDir string, key string, logFile string, foreground bool, maxAttempts int) {
-	logger := initLogger(logFile)
+// runScript runs a single recovery script to completion (or until timeout),
+// streaming its stdout/stderr through logger and reporting its exit code.
+func runScript(scriptDir string, name string, peerID string, lastHeartbeat time.Time, attempts int, tier int, timeout time.Duration, logger *logging.Logger) ScriptResult {
+	entry := logger.WithFields(logging.Fields{"script_name": name, "peer_id": peerID})
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, scriptDir+name)
+	cmd.Env = append(os.Environ(),
+		"WATCHDOG_PEER_ID="+peerID,
+		"WATCHDOG_LAST_HEARTBEAT="+lastHeartbeat.Format(time.RFC3339),
+		"WATCHDOG_ATTEMPTS="+strconv.Itoa(attempts),
+		"WATCHDOG_TIER="+strconv.Itoa(tier),
+	)
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		entry.Errorf("Error opening script stdout: %v", err)
+		return ScriptResult{Name: name, ExitCode: -1}
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		entry.Errorf("Error opening script stderr: %v", err)
+		return ScriptResult{Name: name, ExitCode: -1}
+	}
+
+	if err := cmd.Start(); err != nil {
+		entry.Errorf("Error starting script: %v", err)
+		return ScriptResult{Name: name, ExitCode: -1}
+	}
+
+	var outputWg sync.WaitGroup
+	outputWg.Add(2)
+	go streamOutput(stdout, &outputWg, func(line string) { entry.Debugf("scripts", "stdout: %s", line) })
+	go streamOutput(stderr, &outputWg, func(line string) { entry.Warnf("stderr: %s", line) })
+	outputWg.Wait()
+
+	waitErr := cmd.Wait()
+	if ctx.Err() == context.DeadlineExceeded {
+		entry.Errorf("Script timed out after %s and was killed", timeout)
+		return ScriptResult{Name: name, ExitCode: -1}
+	}
+
+	exitCode := 0
+	if waitErr != nil {
+		var exitErr *exec.ExitError
+		if errors.As(waitErr, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		} else {
+			entry.Errorf("Error running script: %v", waitErr)
+			return ScriptResult{Name: name, ExitCode: -1}
+		}
+	}
+
+	final := logger.WithFields(logging.Fields{"script_name": name, "peer_id": peerID, "exit_code": exitCode})
+	switch exitCode {
+	case 0:
+		final.Noticef("Script succeeded")
+	case exitTempFail:
+		final.Warnf("Script requested a retry next tick (exit %d)", exitTempFail)
+	default:
+		final.Errorf("Script failed, escalating to next tier (exit %d)", exitCode)
+	}
+
+	return ScriptResult{Name: name, ExitCode: exitCode}
+}
+
+// streamOutput reads newline-delimited output from r and passes each line
+// to emit until r is exhausted.
+func streamOutput(r io.Reader, wg *sync.WaitGroup, emit func(string)) {
+	defer wg.Done()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		emit(scanner.Text())
+	}
+}
+
+func startServer(port string, timeout time.Duration, scriptDir string, key string, logFile string, foreground bool, maxAttempts int, tlsEnabled bool, certFile string, tlsKeyFile string, caFile string, mtls bool, legacyAuth bool, maxSkew time.Duration, expectedPeers map[string]time.Duration, logFormat string, syslogEnabled bool, syslogFacility string, syslogTag string, scriptTimeout time.Duration, scriptConcurrency int) {
+	logger := initLogger(logFile, foreground, logFormat, syslogEnabled, syslogFacility, syslogTag)
+	var authenticator *auth.Authenticator
+	if !legacyAuth {
+		authenticator = auth.NewAuthenticator(key, maxSkew)
+	}
 	listener, err := net.Listen("tcp", ":"+port)
 	if err != nil {
-		logger.Println("Error starting server:", err)
+		logger.Errorf("Error starting server: %v", err)
 		return
 	}
 	defer listener.Close()
 
-	logger.Println("Server listening on port", port)
+	if tlsEnabled {
+		tlsConfig, err := buildServerTLSConfig(certFile, tlsKeyFile, caFile, mtls)
+		if err != nil {
+			logger.Errorf("Error configuring TLS: %v", err)
+			return
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+		logger.Infof("TLS enabled (mtls = %v)", mtls)
+	}
 
-	attempts := 0
-	lastHeartbeat := time.Now()
-	var mu sync.Mutex
+	logger.Infof("Server listening on port %s", port)
+
+	registry := NewPeerRegistry(timeout)
+	for id, peerTimeout := range expectedPeers {
+		registry.Expect(id, peerTimeout)
+	}
 
 	ticker := time.NewTicker(timeout)
 	defer ticker.Stop()
 
 	go func() {
 		for range ticker.C {
-			mu.Lock()
-			elapsed := time.Since(lastHeartbeat)
-			if elapsed > timeout {
-				attempts++
-				logger.Println("Heartbeat timeout - failed attempt count:", attempts)
-				if attempts >= maxAttempts {
-					runScripts(scriptDir, logger)
-					attempts = 0
+			for _, pt := range registry.CheckTimeouts(time.Now(), maxAttempts) {
+				logger.WithFields(logging.Fields{"peer_id": pt.ID, "attempt": pt.Attempts}).
+					Warnf("Heartbeat timeout - tier %d", pt.Tier)
+				results := runScripts(scriptDir, pt.Tier, pt.ID, pt.LastHeartbeat, pt.Attempts, scriptTimeout, scriptConcurrency, logger)
+				switch aggregateTierOutcome(results) {
+				case exitTempFail:
+					registry.HoldTier(pt.ID, pt.Tier)
+				case tierOutcomeSuccess:
+					if pt.Tier >= maxAttempts {
+						// Only the top tier's success means the peer is
+						// actually fixed; a lower-tier script exiting 0
+						// (e.g. "01-notify" successfully paging someone)
+						// means the notification was sent, not resolved,
+						// so let the tier keep climbing if the peer stays
+						// quiet.
+						registry.ResetAttempts(pt.ID)
+					} else {
+						registry.ClearTierHold(pt.ID)
+					}
+				default:
+					registry.ClearTierHold(pt.ID)
 				}
-			} else {
-				attempts = 0
 			}
-			mu.Unlock()
-			logger.Println("Heartbeat interval check - attempts:", attempts)
 		}
 	}()
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			logger.Println("Error accepting connection:", err)
+			logger.Errorf("Error accepting connection: %v", err)
 			continue
 		}
-		if handleConnection(conn, key, logger, &lastHeartbeat, &mu) {
-			mu.Lock()
-			attempts = 0
-			mu.Unlock()
-		}
+		go func() {
+			if id, ok := handleConnection(conn, key, legacyAuth, authenticator, logger); ok {
+				registry.Touch(id)
+			}
+		}()
 	}
 }
 
-func startClient(remoteHost string, port string, key string, logFile string, foreground bool, timeout time.Duration) {
-	logger := initLogger(logFile)
+func startClient(remoteHost string, port string, key string, logFile string, foreground bool, timeout time.Duration, tlsEnabled bool, certFile string, tlsKeyFile string, caFile string, mtls bool, legacyAuth bool, clientID string, logFormat string, syslogEnabled bool, syslogFacility string, syslogTag string) {
+	logger := initLogger(logFile, foreground, logFormat, syslogEnabled, syslogFacility, syslogTag)
 	if !foreground {
 		runInBackground()
 	}
-	for {
-		conn, err := net.Dial("tcp", net.JoinHostPort(remoteHost, port))
+
+	var tlsConfig *tls.Config
+	if tlsEnabled {
+		var err error
+		tlsConfig, err = buildClientTLSConfig(certFile, tlsKeyFile, caFile, mtls)
 		if err != nil {
-			logger.Println("Error connecting to server:", err)
-			time.Sleep(timeout)
-			continue
+			logger.Errorf("Error configuring TLS: %v", err)
+			return
+		}
+	}
+
+	for {
+		var conn net.Conn
+		var err error
+		if tlsEnabled {
+			conn, err = tls.Dial("tcp", net.JoinHostPort(remoteHost, port), tlsConfig)
+		} else {
+			conn, err = net.Dial("tcp", net.JoinHostPort(remoteHost, port))
 		}
-		_, err = conn.Write([]byte(strings.TrimSpace(key) + "\n"))
 		if err != nil {
-			logger.Println("Error writing to server:", err)
-			conn.Close()
+			logDialError(logger, err)
 			time.Sleep(timeout)
 			continue
 		}
 		reader := bufio.NewReader(conn)
+		if legacyAuth {
+			_, err = conn.Write([]byte(strings.TrimSpace(key) + "\n"))
+			if err != nil {
+				logger.Errorf("Error writing to server: %v", err)
+				conn.Close()
+				time.Sleep(timeout)
+				continue
+			}
+		} else {
+			if err := auth.Client(conn, reader, key, clientID); err != nil {
+				logger.Warnf("Authentication failed: %v", err)
+				conn.Close()
+				time.Sleep(timeout)
+				continue
+			}
+		}
 		response, err := reader.ReadString('\n')
 		if err != nil {
-			logger.Println("Error reading from server:", err)
+			logger.Errorf("Error reading from server: %v", err)
 		} else {
 			response = strings.TrimSpace(response)
 			if response == "OK" {
-				logger.Println("Server response: OK")
+				logger.Debugf("net", "Server response: OK")
 			} else {
-				logger.Println("Server response: ERROR")
+				logger.Warnf("Server response: ERROR")
 			}
 		}
 		conn.Close()
@@ -174,14 +480,44 @@ func startClient(remoteHost string, port string, key string, logFile string, for
 	}
 }
 
-func initLogger(logFile string) *log.Logger {
-	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Println("Error opening log file:", err)
-		os.Exit(1)
+// initLogger opens logFile for appending and wraps it in a structured
+// logging.Logger. Stdout is only included when foreground is set, so a
+// backgrounded daemon doesn't spam the controlling tty. logFile may also be
+// a "syslog://facility.level/tag" spec (as set via the `logs` config key),
+// in which case the file destination is replaced by syslog. Otherwise
+// syslog is enabled alongside the file when syslogEnabled is set.
+func initLogger(logFile string, foreground bool, format string, syslogEnabled bool, syslogFacility string, syslogTag string) *logging.Logger {
+	var out io.Writer = io.Discard
+
+	if facility, tag, ok := logging.ParseSyslogSpec(logFile); ok {
+		syslogEnabled = true
+		syslogFacility, syslogTag = facility, tag
+		if foreground {
+			out = os.Stdout
+		}
+	} else {
+		file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			fmt.Println("Error opening log file:", err)
+			os.Exit(1)
+		}
+		out = file
+		if foreground {
+			out = io.MultiWriter(file, os.Stdout)
+		}
+	}
+
+	var syslogWriter logging.SyslogWriter
+	if syslogEnabled {
+		w, err := logging.OpenSyslog(syslogFacility, syslogTag)
+		if err != nil {
+			fmt.Println("Error opening syslog:", err)
+		} else {
+			syslogWriter = w
+		}
 	}
-	logger := log.New(io.MultiWriter(file, os.Stdout), "", log.LstdFlags)
-	return logger
+
+	return logging.NewFromEnv(out, format, syslogWriter)
 }
 
 func runInBackground() {
@@ -214,6 +550,21 @@ func main() {
 	logFile := flag.String("logs", "", "Log file (default /var/log/watchdog.log)")
 	foreground := flag.Bool("foreground", false, "Run in foreground")
 	attempts := flag.Int("attempts", 0, "Number of failed attempts before running scripts (default 3)")
+	tlsEnabled := flag.Bool("tls", false, "Encrypt heartbeat connections with TLS")
+	certFile := flag.String("cert", "", "TLS certificate file (required with --tls)")
+	tlsKeyFile := flag.String("key-file", "", "TLS private key file (required with --tls)")
+	caFile := flag.String("ca", "", "CA certificate file used to verify the peer (required with --mtls)")
+	mtls := flag.Bool("mtls", false, "Require mutual TLS: verify the peer's certificate against --ca")
+	legacyAuth := flag.Bool("legacy-auth", false, "Use the legacy plaintext shared-key protocol instead of HMAC challenge-response")
+	maxSkew := flag.Int("max-skew", 0, "Maximum allowed clock skew in seconds for challenge-response timestamps (default 30)")
+	clientID := flag.String("client-id", "", "Client identifier sent during authentication (default: hostname)")
+	expectedPeersFlag := flag.String("expected-peers", "", "Comma-separated peer ids (optionally id:timeout_seconds) expected to check in (server mode)")
+	logFormat := flag.String("log-format", "", "Log output format: text or json (default text)")
+	syslogEnabled := flag.Bool("syslog", false, "Also send log entries to syslog")
+	syslogFacility := flag.String("syslog-facility", "", "Syslog facility to log under (default daemon)")
+	syslogTag := flag.String("syslog-tag", "", "Syslog tag (default watchdog)")
+	scriptTimeout := flag.Int("script-timeout", 0, "Per-script timeout in seconds before it is killed (default 60)")
+	scriptConcurrency := flag.Int("script-concurrency", 0, "Maximum number of recovery scripts to run at once (default 4)")
 
 	flag.Parse()
 
@@ -243,22 +594,91 @@ func main() {
 	if *attempts == 0 {
 		*attempts = getConfigInt(config, "attempts", 3)
 	}
+	if *legacyAuth == false {
+		*legacyAuth = getConfigBool(config, "legacy-auth", false)
+	}
+	if *maxSkew == 0 {
+		*maxSkew = getConfigInt(config, "max-skew", 30)
+	}
+	if *clientID == "" {
+		*clientID = getConfigValue(config, "client-id", "")
+	}
+	if *clientID == "" {
+		if hostname, err := os.Hostname(); err == nil {
+			*clientID = hostname
+		}
+	}
+	if *expectedPeersFlag == "" {
+		*expectedPeersFlag = getConfigValue(config, "expected-peers", "")
+	}
+	if *logFormat == "" {
+		*logFormat = getConfigValue(config, "log-format", "text")
+	}
+	if *syslogEnabled == false {
+		*syslogEnabled = getConfigBool(config, "syslog", false)
+	}
+	if *syslogFacility == "" {
+		*syslogFacility = getConfigValue(config, "syslog-facility", "daemon")
+	}
+	if *syslogTag == "" {
+		*syslogTag = getConfigValue(config, "syslog-tag", "watchdog")
+	}
+	if *scriptTimeout == 0 {
+		*scriptTimeout = getConfigInt(config, "script-timeout", 60)
+	}
+	if *scriptConcurrency == 0 {
+		*scriptConcurrency = getConfigInt(config, "script-concurrency", 4)
+	}
+	if *tlsEnabled == false {
+		*tlsEnabled = getConfigBool(config, "tls", false)
+	}
+	if *certFile == "" {
+		*certFile = getConfigValue(config, "cert", "")
+	}
+	if *tlsKeyFile == "" {
+		*tlsKeyFile = getConfigValue(config, "key-file", "")
+	}
+	if *caFile == "" {
+		*caFile = getConfigValue(config, "ca", "")
+	}
+	if *mtls == false {
+		*mtls = getConfigBool(config, "mtls", false)
+	}
 
 	if *key == "" {
 		fmt.Println("Error: Key must be specified.")
-		fmt.Println("Usage: watchdog --key <key> --server | --client --remote <remote-host> [--port <port>] [--timeout <seconds>] [--dir <directory>] [--logs <logfile>] [--foreground] [--attempts <number>] [--config <config-file>]")
+		fmt.Println(usage)
 		return
 	}
 
 	if *server && *client {
 		fmt.Println("Error: Cannot run as both server and client.")
-		fmt.Println("Usage: watchdog --key <key> --server | --client --remote <remote-host> [--port <port>] [--timeout <seconds>] [--dir <directory>] [--logs <logfile>] [--foreground] [--attempts <number>] [--config <config-file>]")
+		fmt.Println(usage)
 		return
 	}
 
 	if *client && *remoteHost == "" {
 		fmt.Println("Error: Remote host must be specified in client mode.")
-		fmt.Println("Usage: watchdog --key <key> --server | --client --remote <remote-host> [--port <port>] [--timeout <seconds>] [--dir <directory>] [--logs <logfile>] [--foreground] [--attempts <number>] [--config <config-file>]")
+		fmt.Println(usage)
+		return
+	}
+
+	if *mtls {
+		*tlsEnabled = true
+	}
+	if *tlsEnabled && *server && (*certFile == "" || *tlsKeyFile == "") {
+		fmt.Println("Error: --cert and --key-file are required when --tls is enabled in server mode.")
+		fmt.Println(usage)
+		return
+	}
+	if *mtls && *caFile == "" {
+		fmt.Println("Error: --ca is required when --mtls is enabled.")
+		fmt.Println(usage)
+		return
+	}
+	if *mtls && *client && (*certFile == "" || *tlsKeyFile == "") {
+		fmt.Println("Error: --cert and --key-file are required when --mtls is enabled in client mode.")
+		fmt.Println(usage)
 		return
 	}
 
@@ -266,11 +686,16 @@ func main() {
 		if !*foreground {
 			runInBackground()
 		}
-		startServer(*port, time.Duration(*timeout)*time.Second, *scriptDir, *key, *logFile, *foreground, *attempts)
+		expectedPeers, err := parseExpectedPeers(*expectedPeersFlag, time.Duration(*timeout)*time.Second)
+		if err != nil {
+			fmt.Println("Error: invalid --expected-peers:", err)
+			return
+		}
+		startServer(*port, time.Duration(*timeout)*time.Second, *scriptDir, *key, *logFile, *foreground, *attempts, *tlsEnabled, *certFile, *tlsKeyFile, *caFile, *mtls, *legacyAuth, time.Duration(*maxSkew)*time.Second, expectedPeers, *logFormat, *syslogEnabled, *syslogFacility, *syslogTag, time.Duration(*scriptTimeout)*time.Second, *scriptConcurrency)
 	} else if *client {
-		startClient(*remoteHost, *port, *key, *logFile, *foreground, time.Duration(*timeout)*time.Second)
+		startClient(*remoteHost, *port, *key, *logFile, *foreground, time.Duration(*timeout)*time.Second, *tlsEnabled, *certFile, *tlsKeyFile, *caFile, *mtls, *legacyAuth, *clientID, *logFormat, *syslogEnabled, *syslogFacility, *syslogTag)
 	} else {
-		fmt.Println("Usage: watchdog --key <key> --server | --client --remote <remote-host> [--port <port>] [--timeout <seconds>] [--dir <directory>] [--logs <logfile>] [--foreground] [--attempts <number>] [--config <config-file>]")
+		fmt.Println(usage)
 	}
 }
 